@@ -0,0 +1,45 @@
+package inmemorydb
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"io"
+)
+
+// Codec (de)serializes a single value — a snapshot (map[string]entry) or a
+// single WAL record (walRecord) — to and from a byte stream. It mirrors the
+// shape of json.Encoder/Decoder so the built-in codecs are thin wrappers;
+// Decode's v must be a pointer, as with json.Unmarshal.
+type Codec interface {
+	Encode(w io.Writer, v interface{}) error
+	Decode(r io.Reader, v interface{}) error
+}
+
+// JSONCodec is the default Codec and matches this package's original,
+// hardcoded encoding/json behavior. Round-tripping through it loses Go's
+// numeric type distinctions: an int comes back as a float64.
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(w io.Writer, v interface{}) error { return json.NewEncoder(w).Encode(v) }
+func (JSONCodec) Decode(r io.Reader, v interface{}) error { return json.NewDecoder(r).Decode(v) }
+
+// GobCodec preserves Go's concrete types across a restart, unlike JSONCodec.
+// Because entry.Value and walRecord.Value are interface{}, gob must be told
+// the concrete type of anything stored in them: it registers the
+// predeclared basic types (string, int, float64, ...) itself, but any
+// struct type a caller stores as a value must be registered once via
+// gob.Register before it is ever encoded, typically from an init func in
+// the package that defines it. See the memcached subpackage, which
+// registers its own item type for exactly this reason.
+type GobCodec struct{}
+
+func (GobCodec) Encode(w io.Writer, v interface{}) error { return gob.NewEncoder(w).Encode(v) }
+func (GobCodec) Decode(r io.Reader, v interface{}) error { return gob.NewDecoder(r).Decode(v) }
+
+// WithCodec sets the Codec used to serialize snapshots and WAL records.
+// Defaults to JSONCodec.
+func WithCodec(c Codec) Option {
+	return func(s *storage) {
+		s.codec = c
+	}
+}
@@ -0,0 +1,63 @@
+package inmemorydb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// WithEncryption enables AES-GCM at rest for both the snapshot and the
+// WAL: every write is sealed behind a fresh random nonce prepended to the
+// ciphertext, and reads verify and decrypt before anything reaches the
+// configured Codec. key must be 16, 24, or 32 bytes (AES-128/192/256); an
+// invalid length is ignored and storage stays unencrypted, since Option
+// cannot surface a construction error.
+func WithEncryption(key []byte) Option {
+	return func(s *storage) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return
+		}
+		s.aead = aead
+	}
+}
+
+// encrypt seals data behind s.aead if encryption is enabled, prepending a
+// random nonce; it returns data unchanged when no AEAD is configured.
+func (s *storage) encrypt(data []byte) ([]byte, error) {
+	if s.aead == nil {
+		return data, nil
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// Encrypted reports whether WithEncryption configured a usable AEAD cipher.
+func (s *storage) Encrypted() bool {
+	return s.aead != nil
+}
+
+// decrypt reverses encrypt; it returns data unchanged when no AEAD is
+// configured.
+func (s *storage) decrypt(data []byte) ([]byte, error) {
+	if s.aead == nil {
+		return data, nil
+	}
+
+	ns := s.aead.NonceSize()
+	if len(data) < ns {
+		return nil, errors.New("inmemorydb: encrypted payload shorter than nonce")
+	}
+	nonce, ciphertext := data[:ns], data[ns:]
+	return s.aead.Open(nil, nonce, ciphertext, nil)
+}
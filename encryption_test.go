@@ -0,0 +1,36 @@
+package inmemorydb
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedReflectsKeyValidity(t *testing.T) {
+	validKey := []byte("0123456789abcdef0123456789abcdef") // 32 bytes, AES-256
+	invalidKey := []byte("too-short")
+
+	cases := []struct {
+		name string
+		opts []Option
+		want bool
+	}{
+		{"no key supplied", nil, false},
+		{"valid 32-byte key", []Option{WithEncryption(validKey)}, true},
+		{"invalid key length", []Option{WithEncryption(invalidKey)}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "data.json")
+			s, err := NewStorage(path, 0, tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = s.Close() }()
+
+			if got := s.Encrypted(); got != tc.want {
+				t.Fatalf("Encrypted() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package inmemorydb
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Op identifies the kind of mutation an Event describes.
+type Op int
+
+const (
+	OpSet Op = iota
+	OpDelete
+	OpExpire
+)
+
+func (o Op) String() string {
+	switch o {
+	case OpSet:
+		return "SET"
+	case OpDelete:
+		return "DELETE"
+	case OpExpire:
+		return "EXPIRE"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Event describes a single mutation to a key. OldValue is nil for a Set
+// that created a new key; NewValue is nil for Delete and Expire.
+type Event struct {
+	Op        Op
+	Key       string
+	OldValue  interface{}
+	NewValue  interface{}
+	Timestamp time.Time
+}
+
+// subscriberBuffer is the default capacity of channels created by Watch.
+const subscriberBuffer = 64
+
+type eventBus struct {
+	mu          sync.RWMutex
+	subscribers map[chan *Event]struct{}
+	dropped     uint64
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[chan *Event]struct{})}
+}
+
+// Publish broadcasts e to every current subscriber. Sends are non-blocking:
+// a subscriber whose buffer is full has the event dropped rather than
+// stalling the publisher.
+func (b *eventBus) Publish(e *Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			atomic.AddUint64(&b.dropped, 1)
+		}
+	}
+}
+
+// Subscribe registers ch to receive future events. The caller owns ch and
+// must eventually call Unsubscribe to stop receiving on it.
+func (b *eventBus) Subscribe(ch chan *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[ch] = struct{}{}
+}
+
+// Unsubscribe stops ch from receiving further events. It does not close ch.
+func (b *eventBus) Unsubscribe(ch chan *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, ch)
+}
+
+// DroppedEvents reports how many events were dropped because a subscriber's
+// buffer was full.
+func (b *eventBus) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+func (s *storage) publish(e *Event) {
+	s.events.Publish(e)
+}
+
+// Subscribe registers ch to receive every Event published by s.
+func (s *storage) Subscribe(ch chan *Event) {
+	s.events.Subscribe(ch)
+}
+
+// Unsubscribe stops ch from receiving further events.
+func (s *storage) Unsubscribe(ch chan *Event) {
+	s.events.Unsubscribe(ch)
+}
+
+// Watch returns a channel of events for keys starting with prefix, and a
+// cancel func that unsubscribes and releases the underlying resources.
+// Callers should always call cancel once they stop reading from the channel.
+func (s *storage) Watch(prefix string) (<-chan Event, func()) {
+	raw := make(chan *Event, subscriberBuffer)
+	out := make(chan Event, subscriberBuffer)
+	s.Subscribe(raw)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case e := <-raw:
+				if strings.HasPrefix(e.Key, prefix) {
+					select {
+					case out <- *e:
+					default:
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	cancel := func() {
+		close(done)
+		s.Unsubscribe(raw)
+	}
+	return out, cancel
+}
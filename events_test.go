@@ -0,0 +1,85 @@
+package inmemorydb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSubscribeReceivesSetAndDeleteEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	s, err := NewStorage(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+
+	ch := make(chan *Event, 4)
+	s.Subscribe(ch)
+
+	s.Set("a", "1")
+	s.Delete("a")
+
+	want := []Op{OpSet, OpDelete}
+	for _, op := range want {
+		select {
+		case e := <-ch:
+			if e.Op != op || e.Key != "a" {
+				t.Fatalf("got %v %s, want %v a", e.Op, e.Key, op)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %v event", op)
+		}
+	}
+}
+
+func TestUnsubscribeStopsDelivery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	s, err := NewStorage(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+
+	ch := make(chan *Event, 4)
+	s.Subscribe(ch)
+	s.Unsubscribe(ch)
+
+	s.Set("a", "1")
+
+	select {
+	case e := <-ch:
+		t.Fatalf("unexpected event after Unsubscribe: %v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWatchFiltersByPrefix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	s, err := NewStorage(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+
+	events, cancel := s.Watch("user:")
+	defer cancel()
+
+	s.Set("user:1", "alice")
+	s.Set("order:1", "widget")
+
+	select {
+	case e := <-events:
+		if e.Key != "user:1" {
+			t.Fatalf("got key %q, want user:1", e.Key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for filtered event")
+	}
+
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event for non-matching key: %v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
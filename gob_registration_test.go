@@ -0,0 +1,52 @@
+package inmemorydb_test
+
+import (
+	"encoding/gob"
+	"path/filepath"
+	"testing"
+
+	inmemorydb "github.com/vasusheoran/memstore.go"
+	_ "github.com/vasusheoran/memstore.go/memcached" // registers item{} with gob
+)
+
+// TestGobCodecRoundTripsRegisteredStructType verifies that a struct type
+// stored as a value survives a GobCodec-backed restart once it has been
+// registered with gob.Register, which the memcached subpackage does for its
+// own item type in an init func. This is the "caller registers their own
+// concrete types" half of GobCodec's contract.
+func TestGobCodecRoundTripsRegisteredStructType(t *testing.T) {
+	type customStruct struct {
+		A int
+		B string
+	}
+	// Any struct stored through GobCodec must be registered once; do it
+	// here for a type local to this test the same way memcached does for
+	// its own item type.
+	gob.Register(customStruct{})
+
+	path := filepath.Join(t.TempDir(), "data.json")
+	s, err := inmemorydb.NewStorage(path, 0, inmemorydb.WithCodec(inmemorydb.GobCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Set("k", customStruct{A: 1, B: "hi"})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := inmemorydb.NewStorage(path, 0, inmemorydb.WithCodec(inmemorydb.GobCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s2.Close() }()
+
+	v, ok := s2.Get("k")
+	if !ok {
+		t.Fatal("expected k to survive restart")
+	}
+	got, ok := v.(customStruct)
+	if !ok || got != (customStruct{A: 1, B: "hi"}) {
+		t.Fatalf("got %#v, want {A:1 B:hi}", v)
+	}
+}
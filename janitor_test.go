@@ -0,0 +1,27 @@
+package inmemorydb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWithJanitorIntervalZeroDisablesJanitor verifies that a non-positive
+// janitor interval disables the background goroutine instead of panicking
+// via time.NewTicker, mirroring flushPeriod's "zero disables it" idiom.
+func TestWithJanitorIntervalZeroDisablesJanitor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	s, err := NewStorage(path, 0, WithJanitorInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+
+	s.SetWithTTL("k", "v", time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	// No janitor runs, but Get still lazily evicts the expired key.
+	if _, ok := s.Get("k"); ok {
+		t.Fatal("expected expired key to be gone via lazy eviction")
+	}
+}
@@ -1,7 +1,9 @@
 package inmemorydb
 
 import (
-	"encoding/json"
+	"bytes"
+	"crypto/cipher"
+	"io"
 	"os"
 	"sync"
 	"time"
@@ -11,65 +13,227 @@ type Storage interface {
 	Set(key string, value interface{})
 	Get(key string) (interface{}, bool)
 	Delete(key string)
+	SetWithTTL(key string, value interface{}, ttl time.Duration)
+	TTL(key string) (time.Duration, bool)
+	// Update atomically reads and replaces the value at key without
+	// releasing that key's shard lock in between, for read-modify-write
+	// callers like memcached's incr/decr. fn receives the current value
+	// (nil if absent/expired) and whether it existed, and returns the value
+	// to store and whether to store it at all; returning store=false
+	// deletes the key instead. The key's existing ExpiresAt, if any, is left
+	// untouched; use UpdateWithTTL to set a new one as part of the same
+	// atomic step.
+	Update(key string, fn func(value interface{}, existed bool) (newValue interface{}, store bool)) (interface{}, bool)
+	// UpdateWithTTL is Update plus setting a new expiry on the stored entry
+	// in the same atomic step. A ttl of zero or less stores the value with
+	// no expiry.
+	UpdateWithTTL(key string, ttl time.Duration, fn func(value interface{}, existed bool) (newValue interface{}, store bool)) (interface{}, bool)
 	All() map[string]interface{}
 	Close() error
 	Flush() error
+
+	// Encrypted reports whether WithEncryption configured a usable AEAD
+	// cipher. It is false if WithEncryption was never called, and also
+	// false if it was called with an invalid key length — since an Option
+	// cannot itself return a construction error, callers who require
+	// encryption at rest should check this immediately after NewStorage.
+	Encrypted() bool
+
+	// Subscribe registers ch to receive every Event published by this
+	// Storage. Unsubscribe stops delivery; it does not close ch.
+	Subscribe(ch chan *Event)
+	Unsubscribe(ch chan *Event)
+	// Watch returns a channel of events for keys starting with prefix and a
+	// cancel func that must be called once the caller is done watching.
+	Watch(prefix string) (<-chan Event, func())
+}
+
+// entry is the internal envelope every value is stored in. ExpiresAt is the
+// zero time.Time when the key has no expiry.
+type entry struct {
+	Value     interface{} `json:"value"`
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+func (e entry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt)
 }
 
 type storage struct {
-	mu          sync.RWMutex
-	data        map[string]interface{}
-	flushPath   string
-	flushPeriod time.Duration
-	stopChan    chan struct{}
+	shards    []*shard
+	shardMask uint32
+	// structMu serializes the structural operations (All, Flush/compact)
+	// that need a consistent view across every shard at once. Set/Get/
+	// Delete never take it; they only ever touch one shard's own lock.
+	structMu sync.Mutex
+
+	flushPath       string
+	flushPeriod     time.Duration
+	janitorInterval time.Duration
+	stopChan        chan struct{}
+	events          *eventBus
+
+	walMu          sync.Mutex
+	walFile        *os.File
+	walSeq         uint64
+	syncPolicy     SyncPolicy
+	walThreshold   int64
+	lastSync       time.Time
+	compactTrigger chan struct{}
+
+	codec Codec
+	aead  cipher.AEAD
+}
+
+// Option configures a storage instance created by NewStorage.
+type Option func(*storage)
+
+// WithJanitorInterval sets how often the background janitor scans for and
+// evicts expired keys. Defaults to time.Minute when unset; a non-positive d
+// disables the janitor goroutine entirely (expired keys are still evicted
+// lazily by Get), mirroring how flushPeriod of zero disables periodic Flush.
+func WithJanitorInterval(d time.Duration) Option {
+	return func(s *storage) {
+		s.janitorInterval = d
+	}
 }
 
-func NewStorage(flushPath string, flushPeriod time.Duration) Storage {
+// NewStorage opens flushPath (and its companion WAL file), replaying
+// whatever the WAL holds that the last snapshot doesn't, and returns a
+// ready-to-use Storage. It returns a non-nil error if the existing snapshot
+// or WAL can't be read back at all -- e.g. a WithEncryption key that
+// doesn't match whatever the files were written with -- rather than
+// silently starting from an incomplete or empty state.
+func NewStorage(flushPath string, flushPeriod time.Duration, opts ...Option) (Storage, error) {
 	s := &storage{
-		data:        make(map[string]interface{}),
-		flushPath:   flushPath,
-		flushPeriod: flushPeriod,
-		stopChan:    make(chan struct{}),
+		shards:          newShards(defaultShardCount),
+		flushPath:       flushPath,
+		flushPeriod:     flushPeriod,
+		janitorInterval: time.Minute,
+		stopChan:        make(chan struct{}),
+		events:          newEventBus(),
+		syncPolicy:      SyncInterval(time.Second),
+		walThreshold:    defaultWALThreshold,
+		compactTrigger:  make(chan struct{}, 1),
+		codec:           JSONCodec{},
+	}
+	s.shardMask = uint32(len(s.shards) - 1)
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if err := s.loadFromDisk(); err != nil {
+		return nil, err
+	}
+	if err := s.recoverWAL(); err != nil {
+		return nil, err
+	}
+	if err := s.openWAL(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil { // fold the recovered WAL back into the snapshot and trim the records it now covers
+		return nil, err
 	}
-	s.loadFromDisk()
 
 	if flushPeriod != 0 {
 		go s.flushPeriodically()
 	}
+	if s.janitorInterval > 0 {
+		go s.runJanitor()
+	}
+	go s.runCompactor()
 
-	return s
+	return s, nil
 }
 
+// Set, Get, Delete and Update all append their WAL record and publish their
+// Event while still holding the shard's write lock. That is what keeps the
+// WAL and the event stream in the same order as the actual mutations to
+// sh.data: two concurrent writers to the same key are serialized by sh.mu
+// regardless of which one reaches appendWAL's own walMu first, so the WAL
+// can never record [B,A] for a key whose final in-memory value is A's.
 func (s *storage) Set(key string, value interface{}) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.data[key] = value
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	old, existed := sh.data[key]
+	sh.data[key] = entry{Value: value}
+
+	_ = s.appendWAL(walRecord{Op: walOpSet, Key: key, Value: value})
+
+	var oldValue interface{}
+	if existed {
+		oldValue = old.Value
+	}
+	s.publish(&Event{Op: OpSet, Key: key, OldValue: oldValue, NewValue: value, Timestamp: time.Now()})
 }
 
 func (s *storage) Get(key string) (interface{}, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	val, ok := s.data[key]
-	return val, ok
+	sh := s.shardFor(key)
+
+	sh.mu.RLock()
+	e, ok := sh.data[key]
+	sh.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	if !e.expired(time.Now()) {
+		return e.Value, true
+	}
+
+	// Lazily evict the expired entry under the shard's write lock, and
+	// append/publish it from inside that same critical section.
+	sh.mu.Lock()
+	e, ok = sh.data[key]
+	expired := ok && e.expired(time.Now())
+	if expired {
+		delete(sh.data, key)
+		_ = s.appendWAL(walRecord{Op: walOpDelete, Key: key})
+		s.publish(&Event{Op: OpExpire, Key: key, OldValue: e.Value, Timestamp: time.Now()})
+	}
+	sh.mu.Unlock()
+
+	return nil, false
 }
 
 func (s *storage) Delete(key string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.data, key)
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	old, existed := sh.data[key]
+	delete(sh.data, key)
+
+	if existed {
+		_ = s.appendWAL(walRecord{Op: walOpDelete, Key: key})
+		s.publish(&Event{Op: OpDelete, Key: key, OldValue: old.Value, Timestamp: time.Now()})
+	}
+}
+
+// Update preserves the key's existing ExpiresAt by default -- a no-op
+// callback, or one that only changes the value, does not reset a TTL the
+// key already had. Callers that need to set a new expiry as part of the
+// same atomic step should use UpdateWithTTL instead.
+func (s *storage) Update(key string, fn func(value interface{}, existed bool) (interface{}, bool)) (interface{}, bool) {
+	return s.updateWithExpiry(key, fn, nil)
 }
 
-// All returns a copy of the underlying map for read-only purposes
+// All returns a copy of the underlying data for read-only purposes. Expired
+// keys are filtered out but not evicted; the janitor and Get evict them.
 func (s *storage) All() map[string]interface{} {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	now := time.Now()
+	snap := s.snapshot()
 
-	// Create a shallow copy to avoid race conditions
-	copy := make(map[string]interface{}, len(s.data))
-	for k, v := range s.data {
-		copy[k] = v
+	out := make(map[string]interface{}, len(snap))
+	for k, e := range snap {
+		if e.expired(now) {
+			continue
+		}
+		out[k] = e.Value
 	}
-	return copy
+	return out
 }
 
 func (s *storage) flushPeriodically() {
@@ -86,18 +250,37 @@ func (s *storage) flushPeriodically() {
 	}
 }
 
-func (s *storage) Flush() error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *storage) runJanitor() {
+	ticker := time.NewTicker(s.janitorInterval)
+	defer ticker.Stop()
 
-	file, err := os.Create(s.flushPath)
-	if err != nil {
-		return err
+	for {
+		select {
+		case <-ticker.C:
+			s.evictExpired()
+		case <-s.stopChan:
+			return
+		}
 	}
-	defer file.Close()
+}
 
-	encoder := json.NewEncoder(file)
-	return encoder.Encode(s.data)
+func (s *storage) evictExpired() {
+	now := time.Now()
+
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		for k, e := range sh.data {
+			if e.expired(now) {
+				delete(sh.data, k)
+				// Appended/published while still holding sh.mu so this
+				// eviction can't be reordered against a concurrent Set/
+				// Delete on the same key; see the note above Set.
+				_ = s.appendWAL(walRecord{Op: walOpDelete, Key: k})
+				s.publish(&Event{Op: OpExpire, Key: k, OldValue: e.Value, Timestamp: now})
+			}
+		}
+		sh.mu.Unlock()
+	}
 }
 
 func (s *storage) loadFromDisk() error {
@@ -110,11 +293,32 @@ func (s *storage) loadFromDisk() error {
 	}
 	defer file.Close()
 
-	decoder := json.NewDecoder(file)
-	return decoder.Decode(&s.data)
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	payload, err := s.decrypt(raw)
+	if err != nil {
+		return err
+	}
+
+	var snapshot map[string]entry
+	if err := s.codec.Decode(bytes.NewReader(payload), &snapshot); err != nil {
+		return err
+	}
+	for k, e := range snapshot {
+		s.shardFor(k).data[k] = e
+	}
+	return nil
 }
 
 func (s *storage) Close() error {
 	close(s.stopChan)
-	return s.Flush()
+	if err := s.Flush(); err != nil {
+		return err
+	}
+
+	s.walMu.Lock()
+	defer s.walMu.Unlock()
+	return s.walFile.Close()
 }
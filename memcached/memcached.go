@@ -0,0 +1,284 @@
+// Package memcached exposes any inmemorydb.Storage over the memcached text
+// protocol, so existing memcached clients can talk to it without Go bindings.
+package memcached
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	inmemorydb "github.com/vasusheoran/memstore.go"
+)
+
+// item is the envelope stored in Storage for every memcached key: the raw
+// value bytes plus the flags and CAS token memcached clients expect back.
+type item struct {
+	Data  []byte
+	Flags uint32
+	CAS   uint64
+}
+
+func init() {
+	// Required for inmemorydb.GobCodec: item is stored in entry.Value,
+	// an interface{}, and gob only knows the predeclared basic types
+	// without an explicit Register call for everything else.
+	gob.Register(item{})
+}
+
+var casCounter uint64
+
+func nextCAS() uint64 {
+	return atomic.AddUint64(&casCounter, 1)
+}
+
+// ListenAndServe starts a memcached text-protocol server on addr backed by
+// s. It blocks serving connections until the listener errors (for example
+// because the caller closed it).
+func ListenAndServe(addr string, s inmemorydb.Storage) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	srv := &server{storage: s}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.handleConn(conn)
+	}
+}
+
+type server struct {
+	storage inmemorydb.Storage
+}
+
+func (srv *server) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "get", "gets":
+			srv.handleGet(w, fields[1:], fields[0] == "gets")
+		case "set", "add", "replace":
+			if !srv.handleStore(r, w, fields) {
+				w.Flush()
+				return
+			}
+		case "delete":
+			srv.handleDelete(w, fields[1:])
+		case "incr", "decr":
+			srv.handleIncrDecr(w, fields[1:], fields[0] == "incr")
+		case "flush_all":
+			srv.handleFlushAll(w)
+		case "stats":
+			srv.handleStats(w)
+		case "quit":
+			w.Flush()
+			return
+		default:
+			fmt.Fprint(w, "ERROR\r\n")
+		}
+		w.Flush()
+	}
+}
+
+func (srv *server) handleGet(w *bufio.Writer, keys []string, withCAS bool) {
+	for _, key := range keys {
+		v, ok := srv.storage.Get(key)
+		if !ok {
+			continue
+		}
+		it, ok := v.(item)
+		if !ok {
+			continue
+		}
+		if withCAS {
+			fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", key, it.Flags, len(it.Data), it.CAS)
+		} else {
+			fmt.Fprintf(w, "VALUE %s %d %d\r\n", key, it.Flags, len(it.Data))
+		}
+		w.Write(it.Data)
+		fmt.Fprint(w, "\r\n")
+	}
+	fmt.Fprint(w, "END\r\n")
+}
+
+// handleStore consumes a set/add/replace command. It returns false if the
+// connection should be closed because the data block could not be read.
+func (srv *server) handleStore(r *bufio.Reader, w *bufio.Writer, fields []string) bool {
+	if len(fields) < 5 {
+		fmt.Fprint(w, "ERROR\r\n")
+		return true
+	}
+
+	cmd, key := fields[0], fields[1]
+	flags64, _ := strconv.ParseUint(fields[2], 10, 32)
+	exptime, _ := strconv.Atoi(fields[3])
+	length, err := strconv.Atoi(fields[4])
+	if err != nil {
+		fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+		return true
+	}
+	noreply := len(fields) > 5 && fields[5] == "noreply"
+
+	data := make([]byte, length+2) // +2 for the trailing \r\n
+	if _, err := readFull(r, data); err != nil {
+		return false
+	}
+	data = data[:length]
+
+	it := item{Data: data, Flags: uint32(flags64), CAS: nextCAS()}
+
+	// add/replace check existence and store the value and its expiry under
+	// the same key lock via UpdateWithTTL, so two concurrent adds for the
+	// same new key can't both succeed the way a separate Get-then-Set
+	// would allow, and a concurrent mutation of key can't land in the
+	// window between a separate store-then-SetWithTTL pair.
+	stored := false
+	srv.storage.UpdateWithTTL(key, time.Duration(exptime)*time.Second, func(value interface{}, existed bool) (interface{}, bool) {
+		switch cmd {
+		case "add":
+			if existed {
+				return value, existed
+			}
+		case "replace":
+			if !existed {
+				return value, existed
+			}
+		}
+		stored = true
+		return it, true
+	})
+	if !stored {
+		if !noreply {
+			fmt.Fprint(w, "NOT_STORED\r\n")
+		}
+		return true
+	}
+
+	if !noreply {
+		fmt.Fprint(w, "STORED\r\n")
+	}
+	return true
+}
+
+func (srv *server) handleDelete(w *bufio.Writer, fields []string) {
+	if len(fields) == 0 {
+		fmt.Fprint(w, "ERROR\r\n")
+		return
+	}
+	key := fields[0]
+	noreply := len(fields) > 1 && fields[len(fields)-1] == "noreply"
+
+	if _, ok := srv.storage.Get(key); !ok {
+		if !noreply {
+			fmt.Fprint(w, "NOT_FOUND\r\n")
+		}
+		return
+	}
+	srv.storage.Delete(key)
+	if !noreply {
+		fmt.Fprint(w, "DELETED\r\n")
+	}
+}
+
+func (srv *server) handleIncrDecr(w *bufio.Writer, fields []string, incr bool) {
+	if len(fields) < 2 {
+		fmt.Fprint(w, "ERROR\r\n")
+		return
+	}
+	key := fields[0]
+	delta, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		fmt.Fprint(w, "CLIENT_ERROR invalid numeric delta argument\r\n")
+		return
+	}
+
+	var result uint64
+	var badValue, notFound bool
+	// existed's own value (store=true) is returned whenever we decide not
+	// to change anything, rather than (value, false): store=false deletes
+	// the key per Update's contract when existed is true, and a bad or
+	// non-item value should be left untouched, not erased.
+	newValue, stored := srv.storage.Update(key, func(value interface{}, existed bool) (interface{}, bool) {
+		if !existed {
+			notFound = true
+			return value, false
+		}
+		it, ok := value.(item)
+		if !ok {
+			badValue = true
+			return value, true
+		}
+		n, err := strconv.ParseUint(string(it.Data), 10, 64)
+		if err != nil {
+			badValue = true
+			return value, true
+		}
+		if incr {
+			n += delta
+		} else if delta > n {
+			n = 0
+		} else {
+			n -= delta
+		}
+		result = n
+		return item{Data: []byte(strconv.FormatUint(n, 10)), Flags: it.Flags, CAS: nextCAS()}, true
+	})
+	_ = newValue
+
+	switch {
+	case notFound:
+		fmt.Fprint(w, "NOT_FOUND\r\n")
+	case badValue:
+		fmt.Fprint(w, "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+	case stored:
+		fmt.Fprintf(w, "%d\r\n", result)
+	default:
+		fmt.Fprint(w, "NOT_FOUND\r\n")
+	}
+}
+
+func (srv *server) handleFlushAll(w *bufio.Writer) {
+	for key := range srv.storage.All() {
+		srv.storage.Delete(key)
+	}
+	fmt.Fprint(w, "OK\r\n")
+}
+
+func (srv *server) handleStats(w *bufio.Writer) {
+	fmt.Fprintf(w, "STAT curr_items %d\r\n", len(srv.storage.All()))
+	fmt.Fprint(w, "END\r\n")
+}
+
+func readFull(r *bufio.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
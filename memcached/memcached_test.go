@@ -0,0 +1,130 @@
+package memcached
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	inmemorydb "github.com/vasusheoran/memstore.go"
+)
+
+func newTestServer(t *testing.T) *server {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data.json")
+	s, err := inmemorydb.NewStorage(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return &server{storage: s}
+}
+
+// TestIncrOnNonNumericValueLeavesItUntouched exercises the real-memcached
+// behavior of "incr" against a value that isn't a decimal integer: the
+// client sees CLIENT_ERROR and the original value survives, rather than
+// being silently deleted by Update's store=false/existed=true contract.
+func TestIncrOnNonNumericValueLeavesItUntouched(t *testing.T) {
+	srv := newTestServer(t)
+	srv.storage.Set("k", item{Data: []byte("not-a-number")})
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	srv.handleIncrDecr(w, []string{"k", "1"}, true)
+	w.Flush()
+
+	if got := buf.String(); !strings.Contains(got, "CLIENT_ERROR") {
+		t.Fatalf("handleIncrDecr output = %q, want CLIENT_ERROR", got)
+	}
+
+	v, ok := srv.storage.Get("k")
+	if !ok {
+		t.Fatal("expected key k to still exist")
+	}
+	if it, ok := v.(item); !ok || string(it.Data) != "not-a-number" {
+		t.Fatalf("value changed: got %#v", v)
+	}
+}
+
+// TestIncrOnMissingKeyReportsNotFound covers the genuinely-absent-key case,
+// which legitimately should not store anything.
+func TestIncrOnMissingKeyReportsNotFound(t *testing.T) {
+	srv := newTestServer(t)
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	srv.handleIncrDecr(w, []string{"missing", "1"}, true)
+	w.Flush()
+
+	if got := buf.String(); !strings.Contains(got, "NOT_FOUND") {
+		t.Fatalf("handleIncrDecr output = %q, want NOT_FOUND", got)
+	}
+	if _, ok := srv.storage.Get("missing"); ok {
+		t.Fatal("expected missing key to stay absent")
+	}
+}
+
+// TestConcurrentAddIsAtomic fires many concurrent "add" commands at the same
+// new key and checks exactly one of them wins, which requires the
+// existence-check-then-store to happen atomically under the key's lock
+// rather than as a separate Get followed by a Set.
+func TestConcurrentAddIsAtomic(t *testing.T) {
+	srv := newTestServer(t)
+
+	const n = 50
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	stored := 0
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := bufio.NewReader(strings.NewReader("hello\r\n"))
+			var buf bytes.Buffer
+			w := bufio.NewWriter(&buf)
+			srv.handleStore(r, w, []string{"add", "k", "0", "0", "5"})
+			w.Flush()
+			if strings.Contains(buf.String(), "STORED\r\n") && !strings.Contains(buf.String(), "NOT_STORED") {
+				mu.Lock()
+				stored++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if stored != 1 {
+		t.Fatalf("expected exactly 1 successful add, got %d", stored)
+	}
+}
+
+// TestSetWithExptimeAppliesTTLAtomically covers handleStore's exptime
+// handling: the stored value and its expiry land together under one
+// UpdateWithTTL call, so by the time handleStore returns the key already
+// carries the TTL exptime asked for. It runs single-threaded and does not
+// itself race a concurrent reader or writer against the store.
+func TestSetWithExptimeAppliesTTLAtomically(t *testing.T) {
+	srv := newTestServer(t)
+
+	r := bufio.NewReader(strings.NewReader("hello\r\n"))
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	srv.handleStore(r, w, []string{"set", "k", "0", "1", "5"})
+	w.Flush()
+
+	if got := buf.String(); !strings.Contains(got, "STORED\r\n") {
+		t.Fatalf("handleStore output = %q, want STORED", got)
+	}
+
+	ttl, ok := srv.storage.TTL("k")
+	if !ok {
+		t.Fatal("expected k to exist with a TTL right after set")
+	}
+	if ttl <= 0 || ttl > time.Second {
+		t.Fatalf("TTL(k) = %v, want (0, time.Second]", ttl)
+	}
+}
@@ -0,0 +1,406 @@
+package inmemorydb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"time"
+)
+
+// MsgPackCodec is a minimal MessagePack Codec covering exactly the shapes
+// this package ever (de)serializes: map[string]entry snapshots and
+// walRecord structs, including their nested interface{} and time.Time
+// fields. It is not a general-purpose MessagePack library.
+type MsgPackCodec struct{}
+
+const (
+	mpNil     = 0xc0
+	mpFalse   = 0xc2
+	mpTrue    = 0xc3
+	mpFloat64 = 0xcb
+	mpUint64  = 0xcf
+	mpInt64   = 0xd3
+	mpStr16   = 0xda
+	mpStr32   = 0xdb
+	mpBin16   = 0xc5
+	mpBin32   = 0xc6
+	mpArray16 = 0xdc
+	mpArray32 = 0xdd
+	mpMap16   = 0xde
+	mpMap32   = 0xdf
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (MsgPackCodec) Encode(w io.Writer, v interface{}) error {
+	var buf bytes.Buffer
+	if err := mpEncodeValue(&buf, reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (MsgPackCodec) Decode(r io.Reader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("msgpack: Decode target must be a non-nil pointer")
+	}
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = noByteReader{r}
+	}
+	return mpDecodeValue(br, rv.Elem())
+}
+
+// noByteReader adapts an io.Reader without ReadByte to one that has it, one
+// byte at a time, for callers that pass a plain io.Reader to Decode.
+type noByteReader struct{ io.Reader }
+
+func (n noByteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(n, b[:])
+	return b[0], err
+}
+
+func mpWriteHeader(buf *bytes.Buffer, tag16, tag32 byte, n int) {
+	if n <= 0xffff {
+		buf.WriteByte(tag16)
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		buf.Write(b[:])
+		return
+	}
+	buf.WriteByte(tag32)
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n))
+	buf.Write(b[:])
+}
+
+func mpWriteString(buf *bytes.Buffer, s string) {
+	mpWriteHeader(buf, mpStr16, mpStr32, len(s))
+	buf.WriteString(s)
+}
+
+func mpEncodeValue(buf *bytes.Buffer, rv reflect.Value) error {
+	if !rv.IsValid() {
+		buf.WriteByte(mpNil)
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if rv.IsNil() {
+			buf.WriteByte(mpNil)
+			return nil
+		}
+		return mpEncodeValue(buf, rv.Elem())
+
+	case reflect.Bool:
+		if rv.Bool() {
+			buf.WriteByte(mpTrue)
+		} else {
+			buf.WriteByte(mpFalse)
+		}
+		return nil
+
+	case reflect.String:
+		mpWriteString(buf, rv.String())
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf.WriteByte(mpInt64)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(rv.Int()))
+		buf.Write(b[:])
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		buf.WriteByte(mpUint64)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], rv.Uint())
+		buf.Write(b[:])
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		buf.WriteByte(mpFloat64)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(rv.Float()))
+		buf.Write(b[:])
+		return nil
+
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			data := rv.Bytes()
+			mpWriteHeader(buf, mpBin16, mpBin32, len(data))
+			buf.Write(data)
+			return nil
+		}
+		mpWriteHeader(buf, mpArray16, mpArray32, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			if err := mpEncodeValue(buf, rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		mpWriteHeader(buf, mpMap16, mpMap32, rv.Len())
+		for _, k := range rv.MapKeys() {
+			mpWriteString(buf, fmt.Sprint(k.Interface()))
+			if err := mpEncodeValue(buf, rv.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Struct:
+		if rv.Type() == timeType {
+			// time.Time's zero value predates the Unix epoch far enough that
+			// UnixNano overflows int64, so round-trip it as text instead.
+			t := rv.Interface().(time.Time)
+			mpWriteString(buf, t.Format(time.RFC3339Nano))
+			return nil
+		}
+		mpWriteHeader(buf, mpMap16, mpMap32, rv.NumField())
+		for i := 0; i < rv.NumField(); i++ {
+			mpWriteString(buf, rv.Type().Field(i).Name)
+			if err := mpEncodeValue(buf, rv.Field(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("msgpack: unsupported kind %s", rv.Kind())
+	}
+}
+
+func mpReadHeaderLen(r io.ByteReader, tag byte, tag16, tag32 byte) (int, error) {
+	switch tag {
+	case tag16:
+		n, err := mpReadUint(r, 2)
+		return int(n), err
+	case tag32:
+		n, err := mpReadUint(r, 4)
+		return int(n), err
+	default:
+		return 0, fmt.Errorf("msgpack: unexpected tag 0x%x", tag)
+	}
+}
+
+func mpReadUint(r io.ByteReader, n int) (uint64, error) {
+	var v uint64
+	for i := 0; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+func mpReadBytes(r io.ByteReader, n int) ([]byte, error) {
+	out := make([]byte, n)
+	for i := range out {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		out[i] = b
+	}
+	return out, nil
+}
+
+// mpDecodeValue decodes one MessagePack value from r into rv, which must be
+// settable (addressable, or an interface-kind value obtained from Elem()).
+func mpDecodeValue(r io.ByteReader, rv reflect.Value) error {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch tag {
+	case mpNil:
+		if rv.Kind() == reflect.Interface || rv.Kind() == reflect.Ptr {
+			rv.Set(reflect.Zero(rv.Type()))
+		}
+		return nil
+
+	case mpTrue, mpFalse:
+		b := tag == mpTrue
+		return mpSet(rv, reflect.ValueOf(b))
+
+	case mpInt64:
+		n, err := mpReadUint(r, 8)
+		if err != nil {
+			return err
+		}
+		return mpSet(rv, reflect.ValueOf(int64(n)))
+
+	case mpUint64:
+		n, err := mpReadUint(r, 8)
+		if err != nil {
+			return err
+		}
+		return mpSet(rv, reflect.ValueOf(n))
+
+	case mpFloat64:
+		n, err := mpReadUint(r, 8)
+		if err != nil {
+			return err
+		}
+		return mpSet(rv, reflect.ValueOf(math.Float64frombits(n)))
+
+	case mpStr16, mpStr32:
+		n, err := mpReadHeaderLen(r, tag, mpStr16, mpStr32)
+		if err != nil {
+			return err
+		}
+		raw, err := mpReadBytes(r, n)
+		if err != nil {
+			return err
+		}
+		if rv.Kind() == reflect.Struct && rv.Type() == timeType {
+			t, err := time.Parse(time.RFC3339Nano, string(raw))
+			if err != nil {
+				return err
+			}
+			rv.Set(reflect.ValueOf(t))
+			return nil
+		}
+		return mpSet(rv, reflect.ValueOf(string(raw)))
+
+	case mpBin16, mpBin32:
+		n, err := mpReadHeaderLen(r, tag, mpBin16, mpBin32)
+		if err != nil {
+			return err
+		}
+		raw, err := mpReadBytes(r, n)
+		if err != nil {
+			return err
+		}
+		return mpSet(rv, reflect.ValueOf(raw))
+
+	case mpArray16, mpArray32:
+		n, err := mpReadHeaderLen(r, tag, mpArray16, mpArray32)
+		if err != nil {
+			return err
+		}
+		return mpDecodeArray(r, rv, n)
+
+	case mpMap16, mpMap32:
+		n, err := mpReadHeaderLen(r, tag, mpMap16, mpMap32)
+		if err != nil {
+			return err
+		}
+		return mpDecodeMap(r, rv, n)
+
+	default:
+		return fmt.Errorf("msgpack: unknown tag 0x%x", tag)
+	}
+}
+
+func mpDecodeArray(r io.ByteReader, rv reflect.Value, n int) error {
+	target := rv
+	if target.Kind() == reflect.Interface {
+		out := make([]interface{}, n)
+		for i := 0; i < n; i++ {
+			if err := mpDecodeValue(r, reflect.ValueOf(&out[i]).Elem()); err != nil {
+				return err
+			}
+		}
+		rv.Set(reflect.ValueOf(out))
+		return nil
+	}
+
+	if target.Kind() != reflect.Slice {
+		return fmt.Errorf("msgpack: cannot decode array into %s", target.Kind())
+	}
+	out := reflect.MakeSlice(target.Type(), n, n)
+	for i := 0; i < n; i++ {
+		if err := mpDecodeValue(r, out.Index(i)); err != nil {
+			return err
+		}
+	}
+	rv.Set(out)
+	return nil
+}
+
+func mpDecodeMap(r io.ByteReader, rv reflect.Value, n int) error {
+	switch rv.Kind() {
+	case reflect.Interface:
+		out := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			var key string
+			if err := mpDecodeValue(r, reflect.ValueOf(&key).Elem()); err != nil {
+				return err
+			}
+			var val interface{}
+			if err := mpDecodeValue(r, reflect.ValueOf(&val).Elem()); err != nil {
+				return err
+			}
+			out[key] = val
+		}
+		rv.Set(reflect.ValueOf(out))
+		return nil
+
+	case reflect.Map:
+		out := reflect.MakeMapWithSize(rv.Type(), n)
+		elemType := rv.Type().Elem()
+		for i := 0; i < n; i++ {
+			var key string
+			if err := mpDecodeValue(r, reflect.ValueOf(&key).Elem()); err != nil {
+				return err
+			}
+			val := reflect.New(elemType).Elem()
+			if err := mpDecodeValue(r, val); err != nil {
+				return err
+			}
+			out.SetMapIndex(reflect.ValueOf(key), val)
+		}
+		rv.Set(out)
+		return nil
+
+	case reflect.Struct:
+		for i := 0; i < n; i++ {
+			var key string
+			if err := mpDecodeValue(r, reflect.ValueOf(&key).Elem()); err != nil {
+				return err
+			}
+			field := rv.FieldByName(key)
+			if !field.IsValid() {
+				var skip interface{}
+				if err := mpDecodeValue(r, reflect.ValueOf(&skip).Elem()); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := mpDecodeValue(r, field); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("msgpack: cannot decode map into %s", rv.Kind())
+	}
+}
+
+// mpSet assigns val into rv, handling the common case where rv is an
+// interface{}-kind field/slot rather than a concretely typed one.
+func mpSet(rv reflect.Value, val reflect.Value) error {
+	if rv.Kind() == reflect.Interface {
+		rv.Set(val)
+		return nil
+	}
+	if !val.Type().ConvertibleTo(rv.Type()) {
+		return fmt.Errorf("msgpack: cannot assign %s into %s", val.Type(), rv.Type())
+	}
+	rv.Set(val.Convert(rv.Type()))
+	return nil
+}
@@ -0,0 +1,122 @@
+package inmemorydb
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// defaultShardCount is the number of shards used when WithShardCount is not
+// supplied. Must stay a power of two.
+const defaultShardCount = 64
+
+// shard is one stripe of the keyspace: its own map guarded by its own
+// RWMutex, so that Set/Get/Delete against distinct keys never contend.
+type shard struct {
+	mu   sync.RWMutex
+	data map[string]entry
+}
+
+func newShards(n int) []*shard {
+	n = nextPowerOfTwo(n)
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = &shard{data: make(map[string]entry)}
+	}
+	return shards
+}
+
+func nextPowerOfTwo(n int) int {
+	if n < 1 {
+		return 1
+	}
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// WithShardCount sets the number of stripes the keyspace is split across,
+// rounded up to the next power of two. Defaults to 64.
+func WithShardCount(n int) Option {
+	return func(s *storage) {
+		s.shards = newShards(n)
+		s.shardMask = uint32(len(s.shards) - 1)
+	}
+}
+
+func fnv32(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32()
+}
+
+func (s *storage) shardFor(key string) *shard {
+	return s.shards[fnv32(key)&s.shardMask]
+}
+
+// snapshot acquires every shard's read lock in a fixed (index) order and
+// returns a copy of the full keyspace. All goes through this, and compact
+// through snapshotForCompaction, which takes the same locks, so neither ever
+// observes a torn view that mixes pre- and post-mutation state across shards.
+func (s *storage) snapshot() map[string]entry {
+	s.structMu.Lock()
+	defer s.structMu.Unlock()
+
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+	}
+	defer func() {
+		for _, sh := range s.shards {
+			sh.mu.RUnlock()
+		}
+	}()
+
+	out := make(map[string]entry)
+	for _, sh := range s.shards {
+		for k, e := range sh.data {
+			out[k] = e
+		}
+	}
+	return out
+}
+
+// snapshotForCompaction is snapshot plus the WAL file's current length,
+// read while every shard's lock is still held. That makes the pair
+// consistent: a write whose appendWAL call lands after this returns is
+// guaranteed to land past the returned offset in the WAL file, so compact
+// can safely treat everything up to that offset as fully represented in
+// the snapshot and trim only that much.
+func (s *storage) snapshotForCompaction() (map[string]entry, int64, error) {
+	s.structMu.Lock()
+	defer s.structMu.Unlock()
+
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+	}
+	defer func() {
+		for _, sh := range s.shards {
+			sh.mu.RUnlock()
+		}
+	}()
+
+	out := make(map[string]entry)
+	for _, sh := range s.shards {
+		for k, e := range sh.data {
+			out[k] = e
+		}
+	}
+
+	s.walMu.Lock()
+	defer s.walMu.Unlock()
+
+	var walMark int64
+	if s.walFile != nil {
+		info, err := s.walFile.Stat()
+		if err != nil {
+			return nil, 0, err
+		}
+		walMark = info.Size()
+	}
+	return out, walMark, nil
+}
@@ -0,0 +1,58 @@
+package inmemorydb
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// singleMutexStore is a minimal stand-in for the pre-striping storage
+// implementation (one map behind one sync.RWMutex), kept here only to give
+// BenchmarkSingleMutexSet something to compare the sharded storage against.
+type singleMutexStore struct {
+	mu   sync.RWMutex
+	data map[string]interface{}
+}
+
+func newSingleMutexStore() *singleMutexStore {
+	return &singleMutexStore{data: make(map[string]interface{})}
+}
+
+func (s *singleMutexStore) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = value
+}
+
+func benchmarkParallelSet(b *testing.B, set func(key string, value interface{})) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := strconv.Itoa(i)
+			set(key, i)
+			i++
+		}
+	})
+}
+
+func BenchmarkSingleMutexSet(b *testing.B) {
+	store := newSingleMutexStore()
+	benchmarkParallelSet(b, store.Set)
+}
+
+func BenchmarkShardedSet(b *testing.B) {
+	for _, shardCount := range []int{1, 16, 64, 256} {
+		b.Run(fmt.Sprintf("shards=%d", shardCount), func(b *testing.B) {
+			path := filepath.Join(b.TempDir(), "bench.json")
+			s, err := NewStorage(path, 0, WithShardCount(shardCount))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Cleanup(func() { _ = s.Close() })
+			benchmarkParallelSet(b, s.Set)
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package inmemorydb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestUpdatePreservesExistingTTL verifies that Update leaves a key's
+// existing expiry alone when the callback doesn't ask for a new one. A
+// no-op callback used to strip the TTL entirely, because Update always
+// wrote back a zero-value ExpiresAt regardless of what was there before.
+func TestUpdatePreservesExistingTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	s, err := NewStorage(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+
+	s.SetWithTTL("k", "v", time.Hour)
+
+	s.Update("k", func(value interface{}, existed bool) (interface{}, bool) {
+		return value, existed
+	})
+
+	ttl, ok := s.TTL("k")
+	if !ok {
+		t.Fatal("expected k to still exist after Update")
+	}
+	if ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("TTL(k) = %v, want (0, time.Hour] -- Update wiped the existing TTL", ttl)
+	}
+}
+
+// TestUpdateWithTTLSetsNewExpiry verifies that UpdateWithTTL both stores
+// the callback's value and installs the new TTL atomically, overriding
+// whatever expiry the key had before.
+func TestUpdateWithTTLSetsNewExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	s, err := NewStorage(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+
+	s.SetWithTTL("k", "v1", time.Hour)
+
+	s.UpdateWithTTL("k", time.Minute, func(value interface{}, existed bool) (interface{}, bool) {
+		return "v2", true
+	})
+
+	v, ok := s.Get("k")
+	if !ok || v != "v2" {
+		t.Fatalf("Get(k) = %v, %v, want v2, true", v, ok)
+	}
+	ttl, ok := s.TTL("k")
+	if !ok {
+		t.Fatal("expected k to still exist after UpdateWithTTL")
+	}
+	if ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("TTL(k) = %v, want (0, time.Minute]", ttl)
+	}
+}